@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+)
+
+// DBConfig selects which GORM driver and connection string to use, so the
+// backing store can be swapped without a recompile.
+type DBConfig struct {
+	Driver string // "postgres", "mysql" or "sqlite"
+	DSN    string
+}
+
+// DBConfigFromEnv reads DB_DRIVER and DB_DSN from the environment,
+// defaulting to an on-disk SQLite database.
+func DBConfigFromEnv() DBConfig {
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" {
+		driver = "sqlite"
+	}
+	dsn := os.Getenv("DB_DSN")
+	if dsn == "" {
+		dsn = "identity-server.db"
+	}
+	return DBConfig{Driver: driver, DSN: dsn}
+}
+
+// Open opens a GORM connection for the configured driver and migrates the
+// schema for every persisted model.
+func (c DBConfig) Open() (*gorm.DB, error) {
+	var dialector gorm.Dialector
+	switch c.Driver {
+	case "postgres":
+		dialector = postgres.Open(c.DSN)
+	case "mysql":
+		dialector = mysql.Open(c.DSN)
+	case "sqlite":
+		dialector = sqlite.Open(c.DSN)
+	default:
+		return nil, fmt.Errorf("unsupported DB_DRIVER %q", c.Driver)
+	}
+
+	db, err := gorm.Open(dialector, &gorm.Config{})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := db.AutoMigrate(&User{}, &VMDeployment{}, &VM{}, &VMCreationTask{}, &revokedToken{}, &refreshFamilyRow{}); err != nil {
+		return nil, err
+	}
+	return db, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// refreshFamilyRow is the GORM model persisting a refresh-token family, so
+// rotation and reuse detection survive a process restart and are shared
+// across every server instance.
+type refreshFamilyRow struct {
+	FamilyID   string `gorm:"primaryKey"`
+	UserID     string
+	CurrentJTI string
+	Revoked    bool
+}
+
+// gormRefreshFamilyStore is a RefreshFamilyStore backed by a GORM database.
+type gormRefreshFamilyStore struct {
+	db *gorm.DB
+}
+
+// NewGORMRefreshFamilyStore returns a RefreshFamilyStore backed by db.
+func NewGORMRefreshFamilyStore(db *gorm.DB) RefreshFamilyStore {
+	return &gormRefreshFamilyStore{db: db}
+}
+
+func (s *gormRefreshFamilyStore) Create(familyID, userID, jti string) error {
+	return s.db.Create(&refreshFamilyRow{FamilyID: familyID, UserID: userID, CurrentJTI: jti}).Error
+}
+
+// Rotate validates and rotates familyID's jti inside a transaction, row-locking
+// the family so a concurrent refresh against the same family (e.g. landing on
+// a different instance) can't also see the pre-rotation jti as current.
+func (s *gormRefreshFamilyStore) Rotate(familyID, jti, newJTI string) (string, error) {
+	var userID string
+	reused := false
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var row refreshFamilyRow
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&row, "family_id = ?", familyID).Error
+		if err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRefreshFamilyInvalid
+			}
+			return err
+		}
+		if row.Revoked {
+			return ErrRefreshFamilyInvalid
+		}
+		if row.CurrentJTI != jti {
+			// commit the revocation rather than returning an error here,
+			// which would roll it back along with the transaction.
+			row.Revoked = true
+			reused = true
+			return tx.Save(&row).Error
+		}
+		row.CurrentJTI = newJTI
+		userID = row.UserID
+		return tx.Save(&row).Error
+	})
+	if err != nil {
+		return "", err
+	}
+	if reused {
+		return "", ErrRefreshTokenReused
+	}
+	return userID, nil
+}
+
+func (s *gormRefreshFamilyStore) Revoke(familyID string) error {
+	return s.db.Model(&refreshFamilyRow{}).Where("family_id = ?", familyID).Update("revoked", true).Error
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// revokedToken is the GORM model persisting a revoked token's JTI and
+// expiry, so revocations survive a process restart.
+type revokedToken struct {
+	JTI       string    `gorm:"primaryKey"`
+	ExpiresAt time.Time `gorm:"index"`
+}
+
+// gormRevocationStore is a RevocationStore backed by a GORM database.
+type gormRevocationStore struct {
+	db *gorm.DB
+}
+
+// NewGORMRevocationStore returns a RevocationStore backed by db.
+func NewGORMRevocationStore(db *gorm.DB) RevocationStore {
+	return &gormRevocationStore{db: db}
+}
+
+// Revoke marks jti as revoked until exp.
+func (s *gormRevocationStore) Revoke(jti string, exp time.Time) error {
+	return s.db.Save(&revokedToken{JTI: jti, ExpiresAt: exp}).Error
+}
+
+// IsRevoked reports whether jti is present and has not yet expired.
+func (s *gormRevocationStore) IsRevoked(jti string) (bool, error) {
+	var token revokedToken
+	err := s.db.First(&token, "jti = ?", jti).Error
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return false, nil
+		}
+		return false, err
+	}
+	return time.Now().Before(token.ExpiresAt), nil
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// gormUserService is a UserService backed by a GORM database, so users
+// survive a process restart.
+type gormUserService struct {
+	db           *gorm.DB
+	passwordCost int
+}
+
+// NewGORMUserService returns a UserService backed by db, seeding the same
+// embedded users NewUserService does if the users table is empty.
+func NewGORMUserService(db *gorm.DB, passwordCost int) (UserService, error) {
+	s := &gormUserService{db: db, passwordCost: passwordCost}
+
+	var count int64
+	if err := db.Model(&User{}).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		for _, seed := range []User{
+			{ID: "embedded1", Name: "John", Password: "VMware1!"},
+			{ID: "embedded2", Name: "Jane", Password: "VMware1!"},
+		} {
+			hashed, err := hashPassword(seed.Password, passwordCost)
+			if err != nil {
+				return nil, err
+			}
+			seed.Password = hashed
+			if err := db.Create(&seed).Error; err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return s, nil
+}
+
+// CreateUser creates a new user
+func (s *gormUserService) CreateUser(createUserBody *CreateUserBody) (*User, error) {
+	hashed, err := hashPassword(createUserBody.Password, s.passwordCost)
+	if err != nil {
+		return nil, err
+	}
+	user := User{
+		ID:        uuid.NewString(),
+		Name:      createUserBody.Name,
+		Password:  hashed,
+		FirstName: createUserBody.FirstName,
+		LastName:  createUserBody.LastName,
+		Role:      createUserBody.Role,
+		Age:       createUserBody.Age,
+	}
+	if err := s.db.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// GetUser returns a user by ID
+func (s *gormUserService) GetUser(id string) (*User, error) {
+	var user User
+	if err := s.db.First(&user, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("User not found")
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// ListUsers returns a list of all users
+func (s *gormUserService) ListUsers() ([]User, error) {
+	var users []User
+	if err := s.db.Find(&users).Error; err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// DeleteUser deletes a user by ID
+func (s *gormUserService) DeleteUser(id string) error {
+	result := s.db.Delete(&User{}, "id = ?", id)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return errors.New("User not found")
+	}
+	return nil
+}
+
+// UpdateUser updates an existing user. A blank Password leaves the stored
+// hash untouched; use ChangePassword to actually change it.
+func (s *gormUserService) UpdateUser(id string, updateUserBody *CreateUserBody) (*User, error) {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if updateUserBody.Password != "" {
+		hashed, err := hashPassword(updateUserBody.Password, s.passwordCost)
+		if err != nil {
+			return nil, err
+		}
+		user.Password = hashed
+	}
+	user.Name = updateUserBody.Name
+	user.FirstName = updateUserBody.FirstName
+	user.LastName = updateUserBody.LastName
+	user.Role = updateUserBody.Role
+	user.Age = updateUserBody.Age
+	if err := s.db.Save(user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// ValidateCredentials validates the given user credentials, transparently
+// re-hashing the stored password if it was hashed at a lower cost.
+func (s *gormUserService) ValidateCredentials(loginUser *LoginUserBody) (*User, error) {
+	var user User
+	if err := s.db.First(&user, "name = ?", loginUser.Name).Error; err != nil {
+		return nil, errors.New("invalid credentials")
+	}
+	if err := checkPassword(user.Password, loginUser.Password); err != nil {
+		return nil, errors.New("invalid credentials")
+	}
+	if passwordNeedsRehash(user.Password, s.passwordCost) {
+		if hashed, err := hashPassword(loginUser.Password, s.passwordCost); err == nil {
+			user.Password = hashed
+			s.db.Save(&user)
+		}
+	}
+	return &user, nil
+}
+
+// ChangePassword verifies oldPassword against the stored hash for the user
+// with the given id, then replaces it with a hash of newPassword.
+func (s *gormUserService) ChangePassword(id, oldPassword, newPassword string) (*User, error) {
+	user, err := s.GetUser(id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPassword(user.Password, oldPassword); err != nil {
+		return nil, errors.New("invalid credentials")
+	}
+	hashed, err := hashPassword(newPassword, s.passwordCost)
+	if err != nil {
+		return nil, err
+	}
+	user.Password = hashed
+	if err := s.db.Save(user).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// UpsertOAuthUser finds or creates the local user for an external identity.
+func (s *gormUserService) UpsertOAuthUser(provider, subject, email, name string) (*User, error) {
+	var user User
+	err := s.db.First(&user, "provider = ? AND subject = ?", provider, subject).Error
+	if err == nil {
+		user.Name = name
+		user.Email = email
+		if err := s.db.Save(&user).Error; err != nil {
+			return nil, err
+		}
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, err
+	}
+
+	if email != "" {
+		err = s.db.First(&user, "email = ?", email).Error
+		if err == nil {
+			user.Provider = &provider
+			user.Subject = &subject
+			user.Name = name
+			if err := s.db.Save(&user).Error; err != nil {
+				return nil, err
+			}
+			return &user, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+	}
+
+	user = User{
+		ID:       uuid.NewString(),
+		Name:     name,
+		Email:    email,
+		Provider: &provider,
+		Subject:  &subject,
+		Role:     RoleUser,
+	}
+	if err := s.db.Create(&user).Error; err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// maxTaskBackoff caps the exponential retry delay between attempts.
+const maxTaskBackoff = 5 * time.Minute
+
+// gormVMService is a VMService backed by a GORM database. VMCreationTask
+// rows are the durable job queue: CreateVM only inserts a pending row, and
+// the worker pool started by Run claims and processes rows with row-level
+// locking (SELECT ... FOR UPDATE SKIP LOCKED on Postgres/MySQL) so the
+// queue survives a restart and can be shared by multiple server instances.
+// SQLite has no SKIP LOCKED support; with a single connection that's fine
+// since there's only ever one worker pool claiming rows anyway.
+type gormVMService struct {
+	db           *gorm.DB
+	concurrency  int
+	pollInterval time.Duration
+}
+
+// NewGORMVMService returns a VMService backed by db, with concurrency
+// workers each polling for due tasks every pollInterval.
+func NewGORMVMService(db *gorm.DB, concurrency int, pollInterval time.Duration) VMService {
+	return &gormVMService{db: db, concurrency: concurrency, pollInterval: pollInterval}
+}
+
+func (s *gormVMService) CreateVM(createVMBody *CreateVMBody) (*VMCreationTask, error) {
+	deployment := VMDeployment{ID: uuid.New().String(), Status: "in-progress"}
+	if err := s.db.Create(&deployment).Error; err != nil {
+		return nil, err
+	}
+
+	task := VMCreationTask{
+		TaskID:          uuid.New().String(),
+		DeploymentID:    deployment.ID,
+		Status:          TaskPending,
+		MaxAttempts:     defaultMaxTaskAttempts,
+		NextAttemptAt:   time.Now(),
+		RequestName:     createVMBody.Name,
+		RequestNumCPUs:  createVMBody.NumCPUs,
+		RequestMemoryMB: createVMBody.MemoryMB,
+	}
+	if err := s.db.Create(&task).Error; err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (s *gormVMService) GetTask(id string) (*VMCreationTask, error) {
+	var task VMCreationTask
+	if err := s.db.First(&task, "task_id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("task not found")
+		}
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (s *gormVMService) GetDeployment(id string) (*VMDeployment, error) {
+	var deployment VMDeployment
+	if err := s.db.First(&deployment, "id = ?", id).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, errors.New("deployment not found")
+		}
+		return nil, err
+	}
+	return &deployment, nil
+}
+
+func (s *gormVMService) ListTasks() ([]VMCreationTask, error) {
+	var tasks []VMCreationTask
+	if err := s.db.Find(&tasks).Error; err != nil {
+		return nil, err
+	}
+	return tasks, nil
+}
+
+func (s *gormVMService) ListDeployments() ([]VMDeployment, error) {
+	var deployments []VMDeployment
+	if err := s.db.Find(&deployments).Error; err != nil {
+		return nil, err
+	}
+	return deployments, nil
+}
+
+func (s *gormVMService) ListVMs() ([]VM, error) {
+	var vms []VM
+	if err := s.db.Find(&vms).Error; err != nil {
+		return nil, err
+	}
+	return vms, nil
+}
+
+// Run starts the worker pool and blocks until ctx is done, then waits for
+// whatever task each worker is mid-processing to finish before returning.
+func (s *gormVMService) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	wg.Add(s.concurrency)
+	for i := 0; i < s.concurrency; i++ {
+		go func() {
+			defer wg.Done()
+			s.worker(ctx)
+		}()
+	}
+	wg.Wait()
+}
+
+// worker polls for a due task every pollInterval and processes it, until ctx is done.
+func (s *gormVMService) worker(ctx context.Context) {
+	ticker := time.NewTicker(s.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			task, ok := s.claimTask()
+			if !ok {
+				continue
+			}
+			s.process(task)
+		}
+	}
+}
+
+// claimTask locks and claims the oldest due pending/retrying task, marking
+// it running in the same transaction so no other worker can claim it too.
+func (s *gormVMService) claimTask() (*VMCreationTask, bool) {
+	var task VMCreationTask
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("status IN ? AND next_attempt_at <= ?", []string{TaskPending, TaskRetrying}, time.Now()).
+			Order("next_attempt_at").
+			First(&task).Error
+		if err != nil {
+			return err
+		}
+		task.Status = TaskRunning
+		return tx.Save(&task).Error
+	})
+	if err != nil {
+		return nil, false
+	}
+	return &task, true
+}
+
+// process creates the VM for task, marking the task succeeded/failed (or
+// retrying with a backoff) and reflecting the outcome on the deployment.
+func (s *gormVMService) process(task *VMCreationTask) {
+	vm := VM{
+		ID:       uuid.New().String(),
+		Name:     task.RequestName,
+		NumCPUs:  task.RequestNumCPUs,
+		MemoryMB: task.RequestMemoryMB,
+	}
+	if err := s.db.Create(&vm).Error; err != nil {
+		s.retryOrFail(task, err)
+		return
+	}
+
+	s.db.Model(&VMDeployment{}).Where("id = ?", task.DeploymentID).Update("status", "created")
+	task.Status = TaskSucceeded
+	task.Error = ""
+	s.db.Save(task)
+}
+
+// retryOrFail records procErr on task and either schedules the next
+// backed-off attempt or marks the task (and its deployment) failed once
+// MaxAttempts is reached.
+func (s *gormVMService) retryOrFail(task *VMCreationTask, procErr error) {
+	task.Attempts++
+	task.Error = procErr.Error()
+	if task.Attempts >= task.MaxAttempts {
+		task.Status = TaskFailed
+		s.db.Model(&VMDeployment{}).Where("id = ?", task.DeploymentID).Update("status", "failed")
+	} else {
+		task.Status = TaskRetrying
+		task.NextAttemptAt = time.Now().Add(taskBackoff(task.Attempts))
+	}
+	s.db.Save(task)
+}
+
+// taskBackoff returns an exponential delay for the given attempt count, capped at maxTaskBackoff.
+func taskBackoff(attempt int) time.Duration {
+	d := time.Duration(math.Pow(2, float64(attempt))) * time.Second
+	if d > maxTaskBackoff {
+		return maxTaskBackoff
+	}
+	return d
+}
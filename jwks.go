@@ -0,0 +1,54 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+)
+
+// jwksDocument is the body served at GET /.well-known/jwks.json.
+type jwksDocument struct {
+	Keys []jwk `json:"keys"`
+}
+
+// jwk is a single JSON Web Key, covering both the RSA ("RSA") and
+// Ed25519 ("OKP") key types this server can issue.
+type jwk struct {
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Kid string `json:"kid"`
+
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// jwkFromKey renders key's public half as a JWK.
+func jwkFromKey(key *signingKey) jwk {
+	switch pub := key.publicKey.(type) {
+	case *rsa.PublicKey:
+		return jwk{
+			Kty: "RSA",
+			Use: "sig",
+			Alg: string(key.alg),
+			Kid: key.kid,
+			N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+			E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+		}
+	case ed25519.PublicKey:
+		return jwk{
+			Kty: "OKP",
+			Use: "sig",
+			Alg: string(key.alg),
+			Kid: key.kid,
+			Crv: "Ed25519",
+			X:   base64.RawURLEncoding.EncodeToString(pub),
+		}
+	default:
+		return jwk{Kid: key.kid, Alg: string(key.alg)}
+	}
+}
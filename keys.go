@@ -0,0 +1,226 @@
+package main
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// KeyAlg selects which asymmetric algorithm newly generated signing keys use.
+type KeyAlg string
+
+const (
+	AlgRS256 KeyAlg = "RS256"
+	AlgEdDSA KeyAlg = "EdDSA"
+)
+
+// signingKey is one key in the rotation. retiredAt is zero while the key is
+// the active signer; once set, the key is kept around for verification only
+// until that time passes.
+type signingKey struct {
+	kid        string
+	alg        KeyAlg
+	privateKey crypto.Signer
+	publicKey  crypto.PublicKey
+	retiredAt  time.Time
+}
+
+func (k *signingKey) retired() bool {
+	return !k.retiredAt.IsZero() && time.Now().After(k.retiredAt)
+}
+
+// KeyManager owns the set of keys used to sign and verify tokens. Rotating
+// in a new signing key keeps the previous one valid for verification until
+// its grace period elapses, so tokens already issued keep validating.
+type KeyManager struct {
+	mu          sync.RWMutex
+	dir         string
+	alg         KeyAlg
+	gracePeriod time.Duration
+	keys        map[string]*signingKey
+	activeKid   string
+}
+
+// NewKeyManager loads every key found under dir, generating and persisting
+// a first signing key if none are found.
+func NewKeyManager(dir string, alg KeyAlg, gracePeriod time.Duration) (*KeyManager, error) {
+	km := &KeyManager{dir: dir, alg: alg, gracePeriod: gracePeriod, keys: make(map[string]*signingKey)}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".pem" {
+			continue
+		}
+		key, err := loadSigningKey(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("loading key %s: %w", entry.Name(), err)
+		}
+		km.keys[key.kid] = key
+		if key.retiredAt.IsZero() {
+			km.activeKid = key.kid
+		}
+	}
+
+	if km.activeKid == "" {
+		if _, err := km.rotateLocked(); err != nil {
+			return nil, err
+		}
+	}
+
+	return km, nil
+}
+
+// ActiveKey returns the key currently used to sign new tokens.
+func (km *KeyManager) ActiveKey() *signingKey {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	return km.keys[km.activeKid]
+}
+
+// VerificationKey returns the public key and algorithm for kid, as long as
+// kid is known and (for a retired key) still inside its grace period.
+func (km *KeyManager) VerificationKey(kid string) (crypto.PublicKey, KeyAlg, error) {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	key, ok := km.keys[kid]
+	if !ok {
+		return nil, "", fmt.Errorf("unknown key id %q", kid)
+	}
+	if key.retired() {
+		return nil, "", fmt.Errorf("key id %q is past its grace period", kid)
+	}
+	return key.publicKey, key.alg, nil
+}
+
+// JWKS returns the JSON Web Key Set of every key still valid for verification.
+func (km *KeyManager) JWKS() jwksDocument {
+	km.mu.RLock()
+	defer km.mu.RUnlock()
+	var doc jwksDocument
+	for _, key := range km.keys {
+		if key.retired() {
+			continue
+		}
+		doc.Keys = append(doc.Keys, jwkFromKey(key))
+	}
+	return doc
+}
+
+// Rotate generates a new active signing key, retiring the previous one so
+// it remains valid for verification for gracePeriod.
+func (km *KeyManager) Rotate() (string, error) {
+	km.mu.Lock()
+	defer km.mu.Unlock()
+	return km.rotateLocked()
+}
+
+func (km *KeyManager) rotateLocked() (string, error) {
+	key, err := generateSigningKey(km.alg)
+	if err != nil {
+		return "", err
+	}
+	if err := saveSigningKey(km.dir, key); err != nil {
+		return "", err
+	}
+
+	if prev, ok := km.keys[km.activeKid]; ok {
+		prev.retiredAt = time.Now().Add(km.gracePeriod)
+		if err := saveSigningKey(km.dir, prev); err != nil {
+			return "", err
+		}
+	}
+
+	km.keys[key.kid] = key
+	km.activeKid = key.kid
+	return key.kid, nil
+}
+
+// generateSigningKey creates a fresh key pair for alg with a new random kid.
+func generateSigningKey(alg KeyAlg) (*signingKey, error) {
+	kid := uuid.NewString()
+	if alg == AlgEdDSA {
+		pub, priv, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		return &signingKey{kid: kid, alg: AlgEdDSA, privateKey: priv, publicKey: pub}, nil
+	}
+
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+	return &signingKey{kid: kid, alg: AlgRS256, privateKey: priv, publicKey: &priv.PublicKey}, nil
+}
+
+// saveSigningKey persists key to <dir>/<kid>.pem, storing its kid, alg and
+// retirement time as PEM headers alongside the PKCS8-encoded private key.
+func saveSigningKey(dir string, key *signingKey) error {
+	der, err := x509.MarshalPKCS8PrivateKey(key.privateKey)
+	if err != nil {
+		return err
+	}
+	headers := map[string]string{
+		"Kid": key.kid,
+		"Alg": string(key.alg),
+	}
+	if !key.retiredAt.IsZero() {
+		headers["RetiredAt"] = key.retiredAt.Format(time.RFC3339)
+	}
+	block := &pem.Block{Type: "PRIVATE KEY", Headers: headers, Bytes: der}
+	return os.WriteFile(filepath.Join(dir, key.kid+".pem"), pem.EncodeToMemory(block), 0600)
+}
+
+// loadSigningKey reads back a key persisted by saveSigningKey.
+func loadSigningKey(path string) (*signingKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("invalid PEM file")
+	}
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key := &signingKey{kid: block.Headers["Kid"], alg: KeyAlg(block.Headers["Alg"])}
+	if raw := block.Headers["RetiredAt"]; raw != "" {
+		t, err := time.Parse(time.RFC3339, raw)
+		if err != nil {
+			return nil, err
+		}
+		key.retiredAt = t
+	}
+
+	switch signer := private.(type) {
+	case *rsa.PrivateKey:
+		key.privateKey = signer
+		key.publicKey = &signer.PublicKey
+	case ed25519.PrivateKey:
+		key.privateKey = signer
+		key.publicKey = signer.Public()
+	default:
+		return nil, fmt.Errorf("unsupported key type %T", private)
+	}
+	return key, nil
+}
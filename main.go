@@ -1,14 +1,154 @@
 package main
 
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"gorm.io/gorm"
+)
+
+// vmWorkerConcurrency is the number of goroutines processing VM creation
+// tasks concurrently, and vmWorkerPollInterval how often each one checks
+// for a due task.
+const (
+	vmWorkerConcurrency  = 4
+	vmWorkerPollInterval = time.Second
+)
+
+// keyGracePeriod is how long a retired JWT signing key stays valid for
+// verification after rotate-keys brings in a new one.
+const keyGracePeriod = 24 * time.Hour
+
 // main initializes the services and starts the server on port 8080.
 // it uses Gin to handle http routing and to serve the HTTP requests.
+//
+// Persistence is selected via the DB_DRIVER env var: "memory" (the
+// default) keeps everything in-process, while "postgres", "mysql" or
+// "sqlite" back the services with a GORM database configured via
+// DB_DRIVER/DB_DSN (see DBConfigFromEnv). Revoked-token tracking is chosen
+// separately (see revocationStoreFromEnv): REDIS_ADDR selects a Redis/Valkey
+// store for sharing revocations across instances, otherwise it follows
+// DB_DRIVER. JWT signing keys are loaded from (and generated into)
+// JWT_KEYS_DIR; `go run . rotate-keys` rotates in a new signing key without
+// starting the server.
 func main() {
-	tokenService := NewTokenService("secret")
-	userService := NewUserService()
-	vmService := NewVMService()
+	if len(os.Args) > 1 && os.Args[1] == "rotate-keys" {
+		runRotateKeysCLI()
+		return
+	}
+
+	keys, err := NewKeyManager(keysDirFromEnv(), keyAlgFromEnv(), keyGracePeriod)
+	if err != nil {
+		log.Fatalf("failed to load JWT signing keys: %v", err)
+	}
+
+	var (
+		tokenService TokenService
+		userService  UserService
+		vmService    VMService
+	)
+
+	driver := os.Getenv("DB_DRIVER")
+	if driver == "" || driver == "memory" {
+		userService = NewUserService(defaultPasswordCost)
+		vmService = NewVMService()
+		tokenService = NewTokenService(keys, revocationStoreFromEnv(nil), NewMemoryRefreshFamilyStore(), userService)
+	} else {
+		db, err := DBConfigFromEnv().Open()
+		if err != nil {
+			log.Fatalf("failed to open database: %v", err)
+		}
+
+		userService, err = NewGORMUserService(db, defaultPasswordCost)
+		if err != nil {
+			log.Fatalf("failed to initialize user service: %v", err)
+		}
+		vmService = NewGORMVMService(db, vmWorkerConcurrency, vmWorkerPollInterval)
+		tokenService = NewTokenService(keys, revocationStoreFromEnv(db), NewGORMRefreshFamilyStore(db), userService)
+	}
+
+	baseURL := os.Getenv("OAUTH_BASE_URL")
+	if baseURL == "" {
+		baseURL = "http://localhost:8090"
+	}
+	oauthProviders, err := NewOAuthProviders(context.Background(), baseURL)
+	if err != nil {
+		log.Fatalf("failed to configure oauth providers: %v", err)
+	}
+
+	// ctx governs the VM task worker pool so it can drain in-flight work on
+	// shutdown instead of being killed mid-task.
+	ctx, cancel := context.WithCancel(context.Background())
+	vmServiceDone := make(chan struct{})
+	go func() {
+		vmService.Run(ctx)
+		close(vmServiceDone)
+	}()
 
-	go vmService.UpdateDeploymentStatus()
+	server := NewServer(tokenService, userService, vmService, oauthProviders, 8090, "localhost")
+	go server.Run()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+	<-stop
+
+	log.Println("shutting down, draining in-flight VM tasks...")
+	cancel()
+	<-vmServiceDone
+}
+
+// revocationStoreFromEnv picks the RevocationStore to use, independently of
+// DB_DRIVER: REDIS_ADDR configures a Redis/Valkey-backed store so revocations
+// are shared across instances without needing the full SQL database, falling
+// back to db (if non-nil) or, for the in-process "memory" driver, a sweeper
+// backed in-memory store.
+func revocationStoreFromEnv(db *gorm.DB) RevocationStore {
+	if addr := os.Getenv("REDIS_ADDR"); addr != "" {
+		client := redis.NewClient(&redis.Options{
+			Addr:     addr,
+			Password: os.Getenv("REDIS_PASSWORD"),
+		})
+		return NewRedisRevocationStore(client, "revoked:")
+	}
+	if db != nil {
+		return NewGORMRevocationStore(db)
+	}
+	return NewMemoryRevocationStore(time.Minute)
+}
+
+// keysDirFromEnv returns the directory JWT signing keys are loaded from and
+// persisted to, defaulting to "keys" under the working directory.
+func keysDirFromEnv() string {
+	if dir := os.Getenv("JWT_KEYS_DIR"); dir != "" {
+		return dir
+	}
+	return "keys"
+}
+
+// keyAlgFromEnv returns the algorithm used for newly generated signing
+// keys, defaulting to RS256.
+func keyAlgFromEnv() KeyAlg {
+	if os.Getenv("JWT_ALG") == string(AlgEdDSA) {
+		return AlgEdDSA
+	}
+	return AlgRS256
+}
 
-	server := NewServer(tokenService, userService, vmService, 8090, "localhost")
-	server.Run()
+// runRotateKeysCLI rotates in a new JWT signing key without starting the
+// server, for use from a deploy script or cron job.
+func runRotateKeysCLI() {
+	keys, err := NewKeyManager(keysDirFromEnv(), keyAlgFromEnv(), keyGracePeriod)
+	if err != nil {
+		log.Fatalf("failed to load JWT signing keys: %v", err)
+	}
+	kid, err := keys.Rotate()
+	if err != nil {
+		log.Fatalf("failed to rotate signing key: %v", err)
+	}
+	log.Printf("rotated in new signing key %s", kid)
 }
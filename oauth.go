@@ -0,0 +1,167 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/github"
+	"golang.org/x/oauth2/google"
+)
+
+// oauthProvider bundles everything needed to run one OAuth2/OIDC login flow.
+type oauthProvider struct {
+	config *oauth2.Config
+	// fetchIdentity exchanges a token for the provider's stable subject id
+	// plus the user's verified email and display name.
+	fetchIdentity func(ctx context.Context, p *oauthProvider, token *oauth2.Token) (subject, email, name string, err error)
+
+	verifier *oidc.IDTokenVerifier // only set for providers that return an ID token
+}
+
+// oauthProviders holds the configured providers, keyed by the name used in
+// /oauth/:provider/login and /oauth/:provider/callback.
+type oauthProviders map[string]*oauthProvider
+
+// NewOAuthProviders builds the Google and GitHub providers from environment
+// variables (GOOGLE_CLIENT_ID/GOOGLE_CLIENT_SECRET, GITHUB_CLIENT_ID/
+// GITHUB_CLIENT_SECRET). A provider is omitted if its credentials aren't set.
+func NewOAuthProviders(ctx context.Context, redirectBaseURL string) (oauthProviders, error) {
+	providers := oauthProviders{}
+
+	if id, secret := os.Getenv("GOOGLE_CLIENT_ID"), os.Getenv("GOOGLE_CLIENT_SECRET"); id != "" && secret != "" {
+		issuer, err := oidc.NewProvider(ctx, "https://accounts.google.com")
+		if err != nil {
+			return nil, fmt.Errorf("google oidc discovery: %w", err)
+		}
+		providers["google"] = &oauthProvider{
+			config: &oauth2.Config{
+				ClientID:     id,
+				ClientSecret: secret,
+				Endpoint:     google.Endpoint,
+				RedirectURL:  redirectBaseURL + "/oauth/google/callback",
+				Scopes:       []string{oidc.ScopeOpenID, "email", "profile"},
+			},
+			verifier:      issuer.Verifier(&oidc.Config{ClientID: id}),
+			fetchIdentity: fetchGoogleIdentity,
+		}
+	}
+
+	if id, secret := os.Getenv("GITHUB_CLIENT_ID"), os.Getenv("GITHUB_CLIENT_SECRET"); id != "" && secret != "" {
+		providers["github"] = &oauthProvider{
+			config: &oauth2.Config{
+				ClientID:     id,
+				ClientSecret: secret,
+				Endpoint:     github.Endpoint,
+				RedirectURL:  redirectBaseURL + "/oauth/github/callback",
+				Scopes:       []string{"read:user", "user:email"},
+			},
+			fetchIdentity: fetchGitHubIdentity,
+		}
+	}
+
+	return providers, nil
+}
+
+// fetchGoogleIdentity verifies the ID token returned alongside the access
+// token and reads the user's subject, verified email and name from it.
+func fetchGoogleIdentity(ctx context.Context, p *oauthProvider, token *oauth2.Token) (string, string, string, error) {
+	rawIDToken, ok := token.Extra("id_token").(string)
+	if !ok {
+		return "", "", "", fmt.Errorf("google token response missing id_token")
+	}
+	idToken, err := p.verifier.Verify(ctx, rawIDToken)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	var claims struct {
+		Subject       string `json:"sub"`
+		Email         string `json:"email"`
+		EmailVerified bool   `json:"email_verified"`
+		Name          string `json:"name"`
+	}
+	if err := idToken.Claims(&claims); err != nil {
+		return "", "", "", err
+	}
+	if !claims.EmailVerified {
+		return "", "", "", fmt.Errorf("google account email is not verified")
+	}
+	return claims.Subject, claims.Email, claims.Name, nil
+}
+
+// fetchGitHubIdentity calls the GitHub REST API with the access token to
+// resolve the user's id, display name and verified primary email.
+func fetchGitHubIdentity(ctx context.Context, p *oauthProvider, token *oauth2.Token) (string, string, string, error) {
+	client := p.config.Client(ctx, token)
+
+	var profile struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+		Name  string `json:"name"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user", &profile); err != nil {
+		return "", "", "", err
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := getJSON(ctx, client, "https://api.github.com/user/emails", &emails); err != nil {
+		return "", "", "", err
+	}
+
+	var email string
+	for _, e := range emails {
+		if e.Primary && e.Verified {
+			email = e.Email
+			break
+		}
+	}
+	if email == "" {
+		return "", "", "", fmt.Errorf("github account has no verified primary email")
+	}
+
+	name := profile.Name
+	if name == "" {
+		name = profile.Login
+	}
+	return fmt.Sprintf("%d", profile.ID), email, name, nil
+}
+
+// getJSON issues an authenticated GET request and decodes the JSON response into out.
+func getJSON(ctx context.Context, client *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: unexpected status %d: %s", url, resp.StatusCode, body)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// randomOAuthState returns a random, URL-safe state value for the CSRF
+// cookie set by oauthLogin and checked by oauthCallback.
+func randomOAuthState() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
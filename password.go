@@ -0,0 +1,31 @@
+package main
+
+import "golang.org/x/crypto/bcrypt"
+
+// defaultPasswordCost is the bcrypt cost used when none is configured explicitly.
+const defaultPasswordCost = bcrypt.DefaultCost
+
+// hashPassword hashes the given plaintext password using bcrypt at the given cost.
+func hashPassword(password string, cost int) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), cost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// checkPassword compares a plaintext password against a bcrypt hash,
+// returning a non-nil error if they don't match.
+func checkPassword(hash, password string) error {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
+}
+
+// passwordNeedsRehash reports whether a bcrypt hash was generated with a
+// cost lower than the given target cost, meaning it should be re-hashed.
+func passwordNeedsRehash(hash string, targetCost int) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return true
+	}
+	return cost < targetCost
+}
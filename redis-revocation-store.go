@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// redisRevocationStore is a RevocationStore backed by Redis/Valkey, suitable
+// for sharing revocations across multiple server instances. Each revoked
+// JTI is stored as a key with a TTL matching the token's remaining lifetime,
+// so Redis expires it automatically; no sweeper is needed.
+type redisRevocationStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisRevocationStore returns a RevocationStore backed by the given
+// Redis/Valkey client. Keys are namespaced with prefix to avoid colliding
+// with other data in the same database.
+func NewRedisRevocationStore(client *redis.Client, prefix string) RevocationStore {
+	return &redisRevocationStore{client: client, prefix: prefix}
+}
+
+func (s *redisRevocationStore) key(jti string) string {
+	return s.prefix + jti
+}
+
+// Revoke marks jti as revoked until exp, setting a Redis TTL so the key
+// disappears on its own once the token would have expired anyway.
+func (s *redisRevocationStore) Revoke(jti string, exp time.Time) error {
+	ttl := time.Until(exp)
+	if ttl <= 0 {
+		return nil
+	}
+	return s.client.Set(context.Background(), s.key(jti), "1", ttl).Err()
+}
+
+// IsRevoked reports whether jti is currently revoked.
+func (s *redisRevocationStore) IsRevoked(jti string) (bool, error) {
+	n, err := s.client.Exists(context.Background(), s.key(jti)).Result()
+	if err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
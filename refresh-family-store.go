@@ -0,0 +1,84 @@
+package main
+
+import (
+	"errors"
+	"sync"
+)
+
+// ErrRefreshFamilyInvalid is returned when a refresh token's family is
+// unknown or has already been revoked, so the caller must log in again.
+var ErrRefreshFamilyInvalid = errors.New("refresh token is no longer valid, please log in again")
+
+// ErrRefreshTokenReused is returned when a refresh token that has already
+// been rotated away is presented again, signalling the token was stolen.
+var ErrRefreshTokenReused = errors.New("refresh token reuse detected, please log in again")
+
+// RefreshFamilyStore tracks the single refresh token currently valid for
+// each login session (its "family"). The GORM-backed implementation persists
+// families, so rotation and reuse detection survive a process restart and are
+// visible to every server instance; the in-memory one keeps them in-process.
+// Implementations must make Rotate and Revoke safe to call concurrently.
+type RefreshFamilyStore interface {
+	// Create starts tracking a new family whose first valid jti is jti.
+	Create(familyID, userID, jti string) error
+	// Rotate validates that jti is still familyID's current jti and, if
+	// so, replaces it with newJTI, returning the family's userID. If jti
+	// has already been rotated away, the family is revoked and
+	// ErrRefreshTokenReused is returned. If the family is unknown or
+	// already revoked, ErrRefreshFamilyInvalid is returned.
+	Rotate(familyID, jti, newJTI string) (userID string, err error)
+	// Revoke marks a family as no longer valid for refresh.
+	Revoke(familyID string) error
+}
+
+// refreshFamily is one tracked login session. Rotation replaces currentJTI;
+// presenting any other jti for the family is reuse of an already-rotated
+// token and revokes the family.
+type refreshFamily struct {
+	userID     string
+	currentJTI string
+	revoked    bool
+}
+
+// memoryRefreshFamilyStore is an in-memory RefreshFamilyStore keyed by family ID.
+type memoryRefreshFamilyStore struct {
+	mu       sync.Mutex
+	families map[string]*refreshFamily
+}
+
+// NewMemoryRefreshFamilyStore returns a RefreshFamilyStore backed by an
+// in-memory map.
+func NewMemoryRefreshFamilyStore() RefreshFamilyStore {
+	return &memoryRefreshFamilyStore{families: make(map[string]*refreshFamily)}
+}
+
+func (s *memoryRefreshFamilyStore) Create(familyID, userID, jti string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.families[familyID] = &refreshFamily{userID: userID, currentJTI: jti}
+	return nil
+}
+
+func (s *memoryRefreshFamilyStore) Rotate(familyID, jti, newJTI string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	family, ok := s.families[familyID]
+	if !ok || family.revoked {
+		return "", ErrRefreshFamilyInvalid
+	}
+	if family.currentJTI != jti {
+		family.revoked = true
+		return "", ErrRefreshTokenReused
+	}
+	family.currentJTI = newJTI
+	return family.userID, nil
+}
+
+func (s *memoryRefreshFamilyStore) Revoke(familyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if family, ok := s.families[familyID]; ok {
+		family.revoked = true
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// RevocationStore tracks revoked token JTIs until they expire.
+// Implementations must make IsRevoked safe to call concurrently with Revoke.
+type RevocationStore interface {
+	// Revoke marks jti as revoked until exp.
+	Revoke(jti string, exp time.Time) error
+	// IsRevoked reports whether jti has been revoked and has not yet expired.
+	IsRevoked(jti string) (bool, error)
+}
+
+// memoryRevocationStore is an in-memory RevocationStore keyed by JTI, with
+// a background sweeper that evicts entries once they expire so the map
+// doesn't grow without bound.
+type memoryRevocationStore struct {
+	mu      sync.RWMutex
+	entries map[string]time.Time
+
+	sweepInterval time.Duration
+	stop          chan struct{}
+}
+
+// NewMemoryRevocationStore returns a RevocationStore backed by an in-memory
+// map and starts a background goroutine that sweeps expired entries every
+// sweepInterval.
+func NewMemoryRevocationStore(sweepInterval time.Duration) RevocationStore {
+	s := &memoryRevocationStore{
+		entries:       make(map[string]time.Time),
+		sweepInterval: sweepInterval,
+		stop:          make(chan struct{}),
+	}
+	go s.sweep()
+	return s
+}
+
+// Revoke marks jti as revoked until exp.
+func (s *memoryRevocationStore) Revoke(jti string, exp time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entries[jti] = exp
+	return nil
+}
+
+// IsRevoked reports whether jti is present and has not yet expired.
+func (s *memoryRevocationStore) IsRevoked(jti string) (bool, error) {
+	s.mu.RLock()
+	exp, ok := s.entries[jti]
+	s.mu.RUnlock()
+	if !ok {
+		return false, nil
+	}
+	if time.Now().After(exp) {
+		return false, nil
+	}
+	return true, nil
+}
+
+// sweep periodically evicts expired entries. It runs until Close is called.
+func (s *memoryRevocationStore) sweep() {
+	ticker := time.NewTicker(s.sweepInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			now := time.Now()
+			s.mu.Lock()
+			for jti, exp := range s.entries {
+				if now.After(exp) {
+					delete(s.entries, jti)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close stops the background sweeper goroutine.
+func (s *memoryRevocationStore) Close() error {
+	close(s.stop)
+	return nil
+}
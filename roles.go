@@ -0,0 +1,13 @@
+package main
+
+// Role identifies a level of access a user can be assigned. It is a plain
+// string alias so it interops directly with User.Role and the JSON bodies
+// that carry it, while still giving the known roles names to code against.
+type Role = string
+
+// The set of roles the server currently recognizes.
+const (
+	RoleAdmin    Role = "admin"
+	RoleOperator Role = "operator"
+	RoleUser     Role = "user"
+)
@@ -21,6 +21,15 @@ type CreateUserBody struct {
 	Age       int    `json:"age"`
 }
 
+type RefreshTokenBody struct {
+	RefreshToken string `json:"refreshtoken"`
+}
+
+type ChangePasswordBody struct {
+	OldPassword string `json:"oldpassword"`
+	NewPassword string `json:"newpassword"`
+}
+
 type CreateVMBody struct {
 	Name     string `json:"name"`
 	NumCPUs  int    `json:"numcpus"`
@@ -28,39 +37,61 @@ type CreateVMBody struct {
 }
 
 type Server struct {
-	tokenService TokenService
-	userService  UserService
-	vmService    VMService
-	port         int
-	host         string
+	tokenService   TokenService
+	userService    UserService
+	vmService      VMService
+	oauthProviders oauthProviders
+	port           int
+	host           string
 }
 
 // NewServer creates a new server with the given token service, users and port.
-func NewServer(tokenService TokenService, userService UserService, vmService VMService, port int, host string) *Server {
-	return &Server{tokenService: tokenService, userService: userService, vmService: vmService, port: port, host: host}
+func NewServer(tokenService TokenService, userService UserService, vmService VMService, oauthProviders oauthProviders, port int, host string) *Server {
+	return &Server{
+		tokenService:   tokenService,
+		userService:    userService,
+		vmService:      vmService,
+		oauthProviders: oauthProviders,
+		port:           port,
+		host:           host,
+	}
 }
 
 // Run configures http routing using gin library and starts the server.
 func (s *Server) Run() {
+	r := s.router()
+	r.Run(fmt.Sprintf(":%d", s.port))
+	fmt.Printf("Server listening on port %d\n", s.port)
+}
+
+// router builds the gin engine with every route registered. It's split out
+// from Run so tests can exercise the routes without binding a port.
+func (s *Server) router() *gin.Engine {
 	r := gin.Default()
 	r.Use(gin.Recovery())
 	r.POST("/login", s.login)
+	r.POST("/refresh", s.refresh)
+	r.GET("/oauth/:provider/login", s.oauthLogin)
+	r.GET("/oauth/:provider/callback", s.oauthCallback)
+	r.GET("/.well-known/jwks.json", s.jwks)
 	authorized := r.Group("/")
 	authorized.Use(s.AuthMiddleware())
 	{
 		authorized.GET("/", s.home)
-		authorized.POST("/users", s.createUser)
+		authorized.POST("/users", s.RequireRole(RoleAdmin), s.createUser)
 		authorized.GET("/users", s.listUsers)
 		authorized.GET("/users/:id", s.getUser)
 		authorized.PUT("/users/:id", s.updateUser)
-		authorized.DELETE("/users/:id", s.deleteUser)
+		authorized.DELETE("/users/:id", s.RequireRole(RoleAdmin), s.deleteUser)
+		authorized.PUT("/users/:id/password", s.changePassword)
 		authorized.POST("/reject", s.reject)
-		authorized.GET("/rejected", s.listRejected)
-		authorized.POST("/vms", s.createVM)
+		authorized.GET("/rejected", s.RequireRole(RoleAdmin, RoleOperator), s.listRejected)
+		authorized.POST("/vms", s.RequireRole(RoleAdmin, RoleOperator), s.createVM)
+		authorized.GET("/vms/tasks/:id", s.getVMTask)
+		authorized.GET("/deployments/:id", s.getDeployment)
+		authorized.POST("/admin/rotate-keys", s.RequireRole(RoleAdmin), s.rotateKeys)
 	}
-
-	r.Run(fmt.Sprintf(":%d", s.port))
-	fmt.Printf("Server listening on port %d\n", s.port)
+	return r
 }
 
 // AuthMiddleware returns a gin.HandlerFunc that checks if the user is authenticated.
@@ -89,13 +120,36 @@ func (s *Server) AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// set user in context
-		ctx.Set("user", user)
+		// set user and role in context
+		ctx.Set("user", *user)
+		ctx.Set("role", user.Role)
 
 		ctx.Next()
 	}
 }
 
+// RequireRole returns a gin.HandlerFunc that only allows the request through
+// if the authenticated user's role is one of roles. AuthMiddleware must run
+// first so "user" is set in the context. Responds 403 otherwise.
+func (s *Server) RequireRole(roles ...Role) gin.HandlerFunc {
+	allowed := make(map[Role]bool, len(roles))
+	for _, role := range roles {
+		allowed[role] = true
+	}
+
+	return func(ctx *gin.Context) {
+		user := ctx.MustGet("user").(User)
+		if !allowed[user.Role] {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"error": fmt.Sprintf("role %q is not permitted to access this resource", user.Role),
+			})
+			ctx.Abort()
+			return
+		}
+		ctx.Next()
+	}
+}
+
 func (s *Server) home(ctx *gin.Context) {
 	// get user from context
 	user := ctx.MustGet("user").(User)
@@ -160,8 +214,9 @@ func (s *Server) deleteUser(ctx *gin.Context) {
 	ctx.JSON(http.StatusNoContent, nil)
 }
 
-// updateUser updates the user with the given id
-// using the given user body.
+// updateUser updates the user with the given id using the given user body.
+// Only an admin may update another user's account or change a role; a
+// non-admin may update their own account as long as the role is unchanged.
 func (s *Server) updateUser(ctx *gin.Context) {
 	var updateUserBody CreateUserBody
 	if err := ctx.ShouldBindJSON(&updateUserBody); err != nil {
@@ -172,6 +227,29 @@ func (s *Server) updateUser(ctx *gin.Context) {
 	}
 	id := ctx.Param("id")
 
+	actor := ctx.MustGet("user").(User)
+	if actor.Role != RoleAdmin {
+		if actor.ID != id {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"message": "cannot update another user's account",
+			})
+			return
+		}
+		current, err := s.userService.GetUser(id)
+		if err != nil {
+			ctx.JSON(http.StatusNotFound, gin.H{
+				"message": err.Error(),
+			})
+			return
+		}
+		if updateUserBody.Role != current.Role {
+			ctx.JSON(http.StatusForbidden, gin.H{
+				"message": "only an admin can change a user's role",
+			})
+			return
+		}
+	}
+
 	user, err := s.userService.UpdateUser(id, &updateUserBody)
 	if err != nil {
 		ctx.JSON(http.StatusBadRequest, gin.H{
@@ -182,6 +260,27 @@ func (s *Server) updateUser(ctx *gin.Context) {
 	ctx.JSON(http.StatusOK, user)
 }
 
+// changePassword is a handler that changes the password for the user with the given id.
+func (s *Server) changePassword(ctx *gin.Context) {
+	var changePasswordBody ChangePasswordBody
+	if err := ctx.ShouldBindJSON(&changePasswordBody); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	id := ctx.Param("id")
+
+	user, err := s.userService.ChangePassword(id, changePasswordBody.OldPassword, changePasswordBody.NewPassword)
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, user)
+}
+
 // listUsers is a handler that returns a list of all users.
 func (s *Server) listUsers(ctx *gin.Context) {
 	users, err := s.userService.ListUsers()
@@ -213,7 +312,7 @@ func (s *Server) login(ctx *gin.Context) {
 		return
 	}
 
-	token, err := s.tokenService.GenerateToken(user)
+	token, refreshToken, err := s.tokenService.GenerateTokenPair(user)
 	if err != nil {
 		ctx.JSON(http.StatusInternalServerError, gin.H{
 			"message": err.Error(),
@@ -221,7 +320,31 @@ func (s *Server) login(ctx *gin.Context) {
 		return
 	}
 	ctx.JSON(http.StatusOK, gin.H{
-		"token": token,
+		"token":        token,
+		"refreshtoken": refreshToken,
+	})
+}
+
+// refresh is a handler that exchanges a refresh token for a new access/refresh token pair.
+func (s *Server) refresh(ctx *gin.Context) {
+	var refreshTokenBody RefreshTokenBody
+	if err := ctx.ShouldBindJSON(&refreshTokenBody); err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	token, refreshToken, err := s.tokenService.Refresh(refreshTokenBody.RefreshToken)
+	if err != nil {
+		ctx.JSON(http.StatusUnauthorized, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"token":        token,
+		"refreshtoken": refreshToken,
 	})
 }
 
@@ -282,3 +405,130 @@ func (s *Server) createVM(ctx *gin.Context) {
 
 	ctx.JSON(http.StatusCreated, task)
 }
+
+// getVMTask is a handler that returns the current state of a VM creation task.
+func (s *Server) getVMTask(ctx *gin.Context) {
+	task, err := s.vmService.GetTask(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, task)
+}
+
+// getDeployment is a handler that returns the current state of a VM deployment.
+func (s *Server) getDeployment(ctx *gin.Context) {
+	deployment, err := s.vmService.GetDeployment(ctx.Param("id"))
+	if err != nil {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, deployment)
+}
+
+// jwks is a handler that serves the public keys used to verify issued JWTs.
+func (s *Server) jwks(ctx *gin.Context) {
+	ctx.JSON(http.StatusOK, s.tokenService.JWKS())
+}
+
+// rotateKeys is a handler that brings in a new JWT signing key, keeping the
+// previous one valid for verification until its grace period elapses.
+func (s *Server) rotateKeys(ctx *gin.Context) {
+	kid, err := s.tokenService.RotateSigningKey()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"kid": kid,
+	})
+}
+
+// oauthStateCookie is the short-lived cookie that ties an oauthCallback
+// request back to the oauthLogin request that started it, as CSRF protection.
+const oauthStateCookie = "oauth_state"
+
+// oauthLogin redirects the caller to the named provider's consent screen,
+// storing a random state value in a cookie to verify on callback.
+func (s *Server) oauthLogin(ctx *gin.Context) {
+	provider, ok := s.oauthProviders[ctx.Param("provider")]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "unknown oauth provider",
+		})
+		return
+	}
+
+	state, err := randomOAuthState()
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	ctx.SetCookie(oauthStateCookie, state, 300, "/", "", false, true)
+	ctx.Redirect(http.StatusFound, provider.config.AuthCodeURL(state))
+}
+
+// oauthCallback exchanges the authorization code for a token, resolves the
+// provider's identity, upserts a local user and issues an access JWT. Unlike
+// /login, it does not issue a refresh token.
+func (s *Server) oauthCallback(ctx *gin.Context) {
+	provider, ok := s.oauthProviders[ctx.Param("provider")]
+	if !ok {
+		ctx.JSON(http.StatusNotFound, gin.H{
+			"message": "unknown oauth provider",
+		})
+		return
+	}
+
+	state, err := ctx.Cookie(oauthStateCookie)
+	if err != nil || state == "" || state != ctx.Query("state") {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": "invalid oauth state",
+		})
+		return
+	}
+	ctx.SetCookie(oauthStateCookie, "", -1, "/", "", false, true)
+
+	token, err := provider.config.Exchange(ctx, ctx.Query("code"))
+	if err != nil {
+		ctx.JSON(http.StatusBadRequest, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	subject, email, name, err := provider.fetchIdentity(ctx, provider, token)
+	if err != nil {
+		ctx.JSON(http.StatusBadGateway, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	user, err := s.userService.UpsertOAuthUser(ctx.Param("provider"), subject, email, name)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+
+	jwtToken, err := s.tokenService.GenerateToken(user)
+	if err != nil {
+		ctx.JSON(http.StatusInternalServerError, gin.H{
+			"message": err.Error(),
+		})
+		return
+	}
+	ctx.JSON(http.StatusOK, gin.H{
+		"token": jwtToken,
+	})
+}
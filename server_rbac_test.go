@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeTokenService maps a bearer token string directly to the User it
+// authenticates as, so tests don't need to mint real JWTs.
+type fakeTokenService struct {
+	users map[string]User
+}
+
+func (f *fakeTokenService) GenerateToken(user *User) (string, error) { return user.ID, nil }
+func (f *fakeTokenService) ValidateToken(token string) (*User, error) {
+	user, ok := f.users[token]
+	if !ok {
+		return nil, errors.New("invalid token")
+	}
+	return &user, nil
+}
+func (f *fakeTokenService) RejectToken(token string) error       { return nil }
+func (f *fakeTokenService) GetRejectedTokens() ([]string, error) { return nil, nil }
+func (f *fakeTokenService) GenerateTokenPair(user *User) (string, string, error) {
+	return user.ID, user.ID, nil
+}
+func (f *fakeTokenService) Refresh(refreshToken string) (string, string, error) {
+	return "", "", errors.New("not implemented")
+}
+func (f *fakeTokenService) JWKS() jwksDocument                { return jwksDocument{} }
+func (f *fakeTokenService) RotateSigningKey() (string, error) { return "new-kid", nil }
+
+// fakeUserService is an in-memory UserService, just enough of one to drive
+// the route tests below.
+type fakeUserService struct {
+	users map[string]User
+}
+
+func newFakeUserService(seed ...User) *fakeUserService {
+	s := &fakeUserService{users: make(map[string]User)}
+	for _, u := range seed {
+		s.users[u.ID] = u
+	}
+	return s
+}
+
+func (s *fakeUserService) CreateUser(body *CreateUserBody) (*User, error) {
+	user := User{ID: body.Name, Name: body.Name, Role: body.Role}
+	s.users[user.ID] = user
+	return &user, nil
+}
+func (s *fakeUserService) GetUser(id string) (*User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	return &user, nil
+}
+func (s *fakeUserService) ListUsers() ([]User, error) {
+	var users []User
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	return users, nil
+}
+func (s *fakeUserService) DeleteUser(id string) error {
+	if _, ok := s.users[id]; !ok {
+		return errors.New("user not found")
+	}
+	delete(s.users, id)
+	return nil
+}
+func (s *fakeUserService) UpdateUser(id string, body *CreateUserBody) (*User, error) {
+	user, ok := s.users[id]
+	if !ok {
+		return nil, errors.New("user not found")
+	}
+	user.Name = body.Name
+	user.Role = body.Role
+	s.users[id] = user
+	return &user, nil
+}
+func (s *fakeUserService) ValidateCredentials(*LoginUserBody) (*User, error) {
+	return nil, errors.New("not implemented")
+}
+func (s *fakeUserService) ChangePassword(id, oldPassword, newPassword string) (*User, error) {
+	return nil, errors.New("not implemented")
+}
+func (s *fakeUserService) UpsertOAuthUser(provider, subject, email, name string) (*User, error) {
+	return nil, errors.New("not implemented")
+}
+
+// fakeVMService is a minimal VMService that just hands back an empty task,
+// enough to exercise the createVM route's RBAC.
+type fakeVMService struct{}
+
+func (fakeVMService) CreateVM(*CreateVMBody) (*VMCreationTask, error) { return &VMCreationTask{}, nil }
+func (fakeVMService) GetTask(string) (*VMCreationTask, error)         { return &VMCreationTask{}, nil }
+func (fakeVMService) GetDeployment(string) (*VMDeployment, error)     { return &VMDeployment{}, nil }
+func (fakeVMService) ListTasks() ([]VMCreationTask, error)            { return nil, nil }
+func (fakeVMService) ListDeployments() ([]VMDeployment, error)        { return nil, nil }
+func (fakeVMService) ListVMs() ([]VM, error)                          { return nil, nil }
+func (fakeVMService) Run(ctx context.Context)                         { <-ctx.Done() }
+
+// newRBACTestRouter builds a fresh router backed by its own fakeUserService
+// seeded with one user per role, so a case that mutates user state (create,
+// delete, update) can't bleed into a later case.
+func newRBACTestRouter() *gin.Engine {
+	admin := User{ID: "admin-1", Name: "admin", Role: RoleAdmin}
+	operator := User{ID: "op-1", Name: "op", Role: RoleOperator}
+	user := User{ID: "user-1", Name: "user", Role: RoleUser}
+
+	tokens := &fakeTokenService{users: map[string]User{
+		"admin-token":    admin,
+		"operator-token": operator,
+		"user-token":     user,
+	}}
+	users := newFakeUserService(admin, operator, user)
+	server := NewServer(tokens, users, fakeVMService{}, oauthProviders{}, 0, "")
+	return server.router()
+}
+
+// TestRouteRBAC is a table-driven test covering allowed/denied role
+// combinations for every RBAC-protected route registered in router().
+func TestRouteRBAC(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	cases := []struct {
+		name       string
+		method     string
+		path       string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"no token is unauthorized", http.MethodGet, "/users", "", "", http.StatusUnauthorized},
+
+		{"admin can create user", http.MethodPost, "/users", "admin-token", `{"name":"new","role":"user"}`, http.StatusCreated},
+		{"operator cannot create user", http.MethodPost, "/users", "operator-token", `{"name":"new","role":"user"}`, http.StatusForbidden},
+		{"user cannot create user", http.MethodPost, "/users", "user-token", `{"name":"new","role":"user"}`, http.StatusForbidden},
+
+		{"admin can delete another user", http.MethodDelete, "/users/op-1", "admin-token", "", http.StatusNoContent},
+		{"operator cannot delete a user", http.MethodDelete, "/users/user-1", "operator-token", "", http.StatusForbidden},
+		{"user cannot delete a user", http.MethodDelete, "/users/op-1", "user-token", "", http.StatusForbidden},
+
+		{"admin can change another user's role", http.MethodPut, "/users/user-1", "admin-token", `{"name":"user","role":"operator"}`, http.StatusOK},
+		{"user can update own profile without changing role", http.MethodPut, "/users/user-1", "user-token", `{"name":"user","role":"user"}`, http.StatusOK},
+		{"user cannot escalate their own role", http.MethodPut, "/users/user-1", "user-token", `{"name":"user","role":"admin"}`, http.StatusForbidden},
+		{"user cannot update another user's account", http.MethodPut, "/users/admin-1", "user-token", `{"name":"admin","role":"admin"}`, http.StatusForbidden},
+
+		{"admin can list rejected tokens", http.MethodGet, "/rejected", "admin-token", "", http.StatusOK},
+		{"operator can list rejected tokens", http.MethodGet, "/rejected", "operator-token", "", http.StatusOK},
+		{"user cannot list rejected tokens", http.MethodGet, "/rejected", "user-token", "", http.StatusForbidden},
+
+		{"admin can create a vm", http.MethodPost, "/vms", "admin-token", `{"name":"vm1","numcpus":1,"memorymb":512}`, http.StatusCreated},
+		{"operator can create a vm", http.MethodPost, "/vms", "operator-token", `{"name":"vm1","numcpus":1,"memorymb":512}`, http.StatusCreated},
+		{"user cannot create a vm", http.MethodPost, "/vms", "user-token", `{"name":"vm1","numcpus":1,"memorymb":512}`, http.StatusForbidden},
+
+		{"admin can rotate keys", http.MethodPost, "/admin/rotate-keys", "admin-token", "", http.StatusOK},
+		{"operator cannot rotate keys", http.MethodPost, "/admin/rotate-keys", "operator-token", "", http.StatusForbidden},
+		{"user cannot rotate keys", http.MethodPost, "/admin/rotate-keys", "user-token", "", http.StatusForbidden},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			router := newRBACTestRouter()
+
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+			req := httptest.NewRequest(tc.method, tc.path, body)
+			req.Header.Set("Content-Type", "application/json")
+			if tc.token != "" {
+				req.Header.Set("Authorization", "Bearer "+tc.token)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Errorf("%s %s (token %q): got status %d, want %d (body %s)",
+					tc.method, tc.path, tc.token, rec.Code, tc.wantStatus, rec.Body.String())
+			}
+		})
+	}
+}
@@ -1,91 +1,275 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"sync"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 )
 
+// accessTokenTTL is how long an access token issued by GenerateToken stays valid.
+const accessTokenTTL = 10 * time.Minute
+
+// refreshTokenTTL is how long a refresh token issued by GenerateTokenPair stays valid.
+const refreshTokenTTL = 7 * 24 * time.Hour
+
+const (
+	accessTokenAudience  = "access"
+	refreshTokenAudience = "refresh"
+)
+
 type TokenService interface {
 	GenerateToken(user *User) (string, error)
 	ValidateToken(token string) (*User, error)
 	RejectToken(token string) error
 	GetRejectedTokens() ([]string, error)
+	// GenerateTokenPair issues a new access token and a new refresh token
+	// belonging to a fresh refresh-token family for user.
+	GenerateTokenPair(user *User) (access string, refresh string, err error)
+	// Refresh exchanges a refresh token for a new access/refresh pair,
+	// rotating the refresh token's jti. Presenting a refresh token whose
+	// jti has already been rotated away revokes the whole family.
+	Refresh(refreshToken string) (access string, refresh string, err error)
+	// JWKS returns the public half of every signing key still valid for
+	// verification, for serving at GET /.well-known/jwks.json.
+	JWKS() jwksDocument
+	// RotateSigningKey brings in a new active signing key, keeping the
+	// previous one valid for verification until its grace period elapses.
+	RotateSigningKey() (string, error)
 }
 
 type tokenService struct {
-	secret         string
+	keys  *KeyManager
+	store RevocationStore
+
+	mu             sync.Mutex
 	rejectedTokens []string
+
+	families RefreshFamilyStore
+	users    UserService
 }
 
-// NewTokenService returns a new token service.
-func NewTokenService(secret string) TokenService {
-	return &tokenService{secret: secret}
+// NewTokenService returns a new token service that signs with keys, records
+// revoked tokens in store, tracks refresh-token families in families, and
+// re-validates a user's current Name/Role against users on every Refresh.
+func NewTokenService(keys *KeyManager, store RevocationStore, families RefreshFamilyStore, users UserService) TokenService {
+	return &tokenService{
+		keys:     keys,
+		store:    store,
+		families: families,
+		users:    users,
+	}
+}
+
+// jwtSigningMethod maps a KeyAlg to the jwt-go signing method that issues and verifies it.
+func jwtSigningMethod(alg KeyAlg) jwt.SigningMethod {
+	if alg == AlgEdDSA {
+		return jwt.SigningMethodEdDSA
+	}
+	return jwt.SigningMethodRS256
 }
 
-// GenerateToken generates a JWT token for the given user.
-// The token is valid for 10 minutes.
+// sign signs claims with the active signing key, embedding its kid in the token header.
+func (t *tokenService) sign(claims jwt.MapClaims) (string, error) {
+	key := t.keys.ActiveKey()
+	token := jwt.NewWithClaims(jwtSigningMethod(key.alg), claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.privateKey)
+}
+
+// GenerateToken generates an access JWT token for the given user.
+// The token is valid for accessTokenTTL.
 func (t *tokenService) GenerateToken(user *User) (string, error) {
-	token := jwt.New(jwt.SigningMethodHS256)
-	claims := token.Claims.(jwt.MapClaims)
-	claims["user_id"] = user.ID
-	claims["role"] = user.Role
-	claims["name"] = user.Name
-	claims["exp"] = time.Now().Add(time.Minute * 10).Unix()
-	claims["jti"] = uuid.NewString()
-	tokenString, err := token.SignedString([]byte(t.secret))
-	return tokenString, err
+	return t.sign(jwt.MapClaims{
+		"user_id": user.ID,
+		"role":    user.Role,
+		"name":    user.Name,
+		"aud":     accessTokenAudience,
+		"exp":     time.Now().Add(accessTokenTTL).Unix(),
+		"jti":     uuid.NewString(),
+	})
 }
 
-// ValidateToken validates the given JWT token.
-// If the token is valid, it returns the user associated with the token.
-// If the token is not valid, it returns an error.
-func (t *tokenService) ValidateToken(tokenString string) (*User, error) {
-	// parse the token string
+// GenerateTokenPair issues a new access token together with a new refresh
+// token, starting a new refresh-token family for user.
+func (t *tokenService) GenerateTokenPair(user *User) (string, string, error) {
+	access, err := t.GenerateToken(user)
+	if err != nil {
+		return "", "", err
+	}
+
+	familyID := uuid.NewString()
+	jti := uuid.NewString()
+	refresh, err := t.signRefreshToken(user, familyID, jti)
+	if err != nil {
+		return "", "", err
+	}
+	if err := t.families.Create(familyID, user.ID, jti); err != nil {
+		return "", "", err
+	}
+
+	return access, refresh, nil
+}
+
+// signRefreshToken builds and signs a refresh token for user in the given
+// family, with jti as its id.
+func (t *tokenService) signRefreshToken(user *User, familyID, jti string) (string, error) {
+	return t.sign(jwt.MapClaims{
+		"user_id": user.ID,
+		"role":    user.Role,
+		"name":    user.Name,
+		"aud":     refreshTokenAudience,
+		"family":  familyID,
+		"exp":     time.Now().Add(refreshTokenTTL).Unix(),
+		"jti":     jti,
+	})
+}
+
+// Refresh exchanges a valid, not-yet-rotated refresh token for a new
+// access/refresh pair. If the presented refresh token's jti no longer
+// matches the family's current jti, it has already been rotated away and
+// is being replayed, so the whole family is revoked and re-login is required.
+// The reissued tokens' Name/Role are re-read from users rather than trusted
+// from the presented token's claims, so a role change (or deletion) takes
+// effect the next time the caller refreshes instead of only at re-login.
+func (t *tokenService) Refresh(refreshToken string) (string, string, error) {
+	claims, err := t.parseClaims(refreshToken)
+	if err != nil {
+		return "", "", err
+	}
+	if claims["aud"] != refreshTokenAudience {
+		return "", "", fmt.Errorf("token is not a refresh token")
+	}
+
+	familyID, _ := claims["family"].(string)
+	jti := claims["jti"].(string)
+	exp := time.Unix(int64(claims["exp"].(float64)), 0)
+
+	newJTI := uuid.NewString()
+	userID, err := t.families.Rotate(familyID, jti, newJTI)
+	if err != nil {
+		if errors.Is(err, ErrRefreshTokenReused) {
+			_ = t.store.Revoke(jti, exp)
+		}
+		return "", "", err
+	}
+
+	if err := t.store.Revoke(jti, exp); err != nil {
+		return "", "", err
+	}
+
+	user, err := t.users.GetUser(userID)
+	if err != nil {
+		_ = t.families.Revoke(familyID)
+		return "", "", fmt.Errorf("user no longer exists")
+	}
+
+	newRefresh, err := t.signRefreshToken(user, familyID, newJTI)
+	if err != nil {
+		return "", "", err
+	}
+
+	access, err := t.GenerateToken(user)
+	if err != nil {
+		return "", "", err
+	}
+	return access, newRefresh, nil
+}
+
+// parseClaims parses tokenString, verifying it against the signing key
+// named by its kid header, and returns its claims. Tokens whose kid is
+// unknown (or past its rotation grace period) are rejected.
+func (t *tokenService) parseClaims(tokenString string) (jwt.MapClaims, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
+		kid, ok := token.Header["kid"].(string)
+		if !ok {
+			return nil, fmt.Errorf("token is missing a kid header")
+		}
+		publicKey, alg, err := t.keys.VerificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		if token.Method.Alg() != string(alg) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
-		return []byte(t.secret), nil
+		return publicKey, nil
 	})
 	if err != nil {
 		return nil, err
 	}
-	// check if tokens jti claim is on rejected list
-	for _, rejectedJTI := range t.rejectedTokens {
-		if rejectedJTI == token.Claims.(jwt.MapClaims)["jti"].(string) {
-			return nil, fmt.Errorf("token is rejected")
-		}
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok || !token.Valid {
+		return nil, fmt.Errorf("invalid token")
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		return &User{
-				ID:   string(claims["user_id"].(string)),
-				Name: string(claims["name"].(string))},
-			nil
+	return claims, nil
+}
+
+// ValidateToken validates the given JWT token.
+// If the token is valid, it returns the user associated with the token.
+// If the token is not valid, it returns an error.
+func (t *tokenService) ValidateToken(tokenString string) (*User, error) {
+	claims, err := t.parseClaims(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	// access-protected routes must not accept refresh tokens
+	if aud, ok := claims["aud"]; ok && aud != accessTokenAudience {
+		return nil, fmt.Errorf("token is not an access token")
+	}
+	// check if the token's jti claim has been revoked
+	revoked, err := t.store.IsRevoked(claims["jti"].(string))
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, fmt.Errorf("token is rejected")
 	}
-	return nil, fmt.Errorf("invalid token")
+	return &User{
+		ID:   claims["user_id"].(string),
+		Name: claims["name"].(string),
+		Role: claims["role"].(string),
+	}, nil
 }
 
 // RejectToken decodes token and rejects the given JWT token by adding it's id to the list of rejected tokens.
 func (t *tokenService) RejectToken(tokenString string) error {
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
-		}
-		return []byte(t.secret), nil
-	})
+	claims, err := t.parseClaims(tokenString)
 	if err != nil {
 		return err
 	}
-	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		t.rejectedTokens = append(t.rejectedTokens, string(claims["jti"].(string)))
+	jti := claims["jti"].(string)
+	exp := time.Unix(int64(claims["exp"].(float64)), 0)
+	if err := t.store.Revoke(jti, exp); err != nil {
+		return err
 	}
+	t.mu.Lock()
+	t.rejectedTokens = append(t.rejectedTokens, jti)
+	t.mu.Unlock()
 	return nil
 }
 
-// GetRejectedTokens returns the list of rejected tokens.
+// GetRejectedTokens returns the JTIs of tokens rejected on this instance.
+// This is a local, best-effort record kept alongside the revocation store
+// purely to serve this listing endpoint; the store itself is the source of
+// truth for whether a token is revoked.
 func (t *tokenService) GetRejectedTokens() ([]string, error) {
-	return t.rejectedTokens, nil
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tokens := make([]string, len(t.rejectedTokens))
+	copy(tokens, t.rejectedTokens)
+	return tokens, nil
+}
+
+// JWKS returns the public half of every signing key still valid for verification.
+func (t *tokenService) JWKS() jwksDocument {
+	return t.keys.JWKS()
+}
+
+// RotateSigningKey brings in a new active signing key, keeping the previous
+// one valid for verification until its grace period elapses.
+func (t *tokenService) RotateSigningKey() (string, error) {
+	return t.keys.Rotate()
 }
@@ -7,13 +7,25 @@ import (
 )
 
 type User struct {
-	ID        string
-	Name      string
-	Password  string
+	ID        string `gorm:"primaryKey"`
+	Name      string `gorm:"uniqueIndex"`
+	Password  string `json:"-"`
 	FirstName string
 	LastName  string
 	Role      string
 	Age       int
+
+	// Email, Provider and Subject identify a user that logged in via an
+	// external OAuth2/OIDC provider (see oauth.go). Provider+Subject is the
+	// stable external identity; Email is used to link that identity to an
+	// existing local account on first login. Provider/Subject are pointers
+	// so a local (non-OAuth) user stores them as SQL NULL rather than "":
+	// NULL is never equal to NULL, so idx_provider_subject's uniqueness
+	// only ever applies between actual OAuth identities, not every local
+	// user's shared ("", "") pair.
+	Email    string  `gorm:"index"`
+	Provider *string `gorm:"uniqueIndex:idx_provider_subject"`
+	Subject  *string `gorm:"uniqueIndex:idx_provider_subject"`
 }
 
 type UserService interface {
@@ -23,28 +35,48 @@ type UserService interface {
 	DeleteUser(id string) error
 	UpdateUser(id string, updateUserBody *CreateUserBody) (*User, error)
 	ValidateCredentials(loginUser *LoginUserBody) (*User, error)
+	ChangePassword(id, oldPassword, newPassword string) (*User, error)
+	// UpsertOAuthUser finds or creates the local user for an external
+	// identity. A user is matched first by provider+subject, then by
+	// email, and is created if neither matches.
+	UpsertOAuthUser(provider, subject, email, name string) (*User, error)
 }
 
 type userService struct {
-	users []User
+	users        []User
+	passwordCost int
 }
 
-// NewUserService returns a new user service.
-func NewUserService() UserService {
-	return &userService{
-		users: []User{
-			{ID: "embedded1", Name: "John", Password: "VMware1!"},
-			{ID: "embedded2", Name: "Jane", Password: "VMware1!"},
-		},
+// NewUserService returns a new user service that hashes passwords at the given bcrypt cost.
+func NewUserService(passwordCost int) UserService {
+	s := &userService{passwordCost: passwordCost}
+
+	for _, seed := range []User{
+		{ID: "embedded1", Name: "John", Password: "VMware1!"},
+		{ID: "embedded2", Name: "Jane", Password: "VMware1!"},
+	} {
+		hashed, err := hashPassword(seed.Password, s.passwordCost)
+		if err != nil {
+			// seed users are hard-coded, so this can only fail on a bad cost.
+			panic(err)
+		}
+		seed.Password = hashed
+		s.users = append(s.users, seed)
 	}
+
+	return s
 }
 
 // CreateUser creates a new user
 func (s *userService) CreateUser(createUserBody *CreateUserBody) (*User, error) {
+	hashed, err := hashPassword(createUserBody.Password, s.passwordCost)
+	if err != nil {
+		return nil, err
+	}
 	newUser := User{
 		ID:        uuid.NewString(),
 		Name:      createUserBody.Name,
-		Password:  createUserBody.Password,
+		Password:  hashed,
 		FirstName: createUserBody.FirstName,
 		LastName:  createUserBody.LastName,
 		Role:      createUserBody.Role,
@@ -80,15 +112,22 @@ func (s *userService) DeleteUser(id string) error {
 	return errors.New("User not found")
 }
 
-// UpdateUser updates an existing user
+// UpdateUser updates an existing user. A blank Password leaves the stored
+// hash untouched; use ChangePassword to actually change it.
 func (s *userService) UpdateUser(id string, updateUserBody *CreateUserBody) (*User, error) {
 	for i, u := range s.users {
 		if u.ID == id {
+			if updateUserBody.Password != "" {
+				hashed, err := hashPassword(updateUserBody.Password, s.passwordCost)
+				if err != nil {
+					return nil, err
+				}
+				s.users[i].Password = hashed
+			}
 			// update the user properties
 			s.users[i].Name = updateUserBody.Name
 			s.users[i].FirstName = updateUserBody.FirstName
 			s.users[i].LastName = updateUserBody.LastName
-			s.users[i].Password = updateUserBody.Password
 			s.users[i].Role = updateUserBody.Role
 			s.users[i].Age = updateUserBody.Age
 			return &s.users[i], nil
@@ -97,12 +136,72 @@ func (s *userService) UpdateUser(id string, updateUserBody *CreateUserBody) (*Us
 	return nil, errors.New("user not found")
 }
 
-// ValidateCredentials validates the given user credentials
+// ValidateCredentials validates the given user credentials.
+// If the stored hash was generated at a lower cost than the service is
+// currently configured for, it is transparently re-hashed on success.
 func (s *userService) ValidateCredentials(loginUser *LoginUserBody) (*User, error) {
-	for _, user := range s.users {
-		if user.Name == loginUser.Name && user.Password == loginUser.Password {
-			return &user, nil
+	for i, user := range s.users {
+		if user.Name != loginUser.Name {
+			continue
+		}
+		if err := checkPassword(user.Password, loginUser.Password); err != nil {
+			return nil, errors.New("invalid credentials")
 		}
+		if passwordNeedsRehash(user.Password, s.passwordCost) {
+			if hashed, err := hashPassword(loginUser.Password, s.passwordCost); err == nil {
+				s.users[i].Password = hashed
+			}
+		}
+		return &s.users[i], nil
 	}
 	return nil, errors.New("invalid credentials")
 }
+
+// ChangePassword verifies oldPassword against the stored hash for the user
+// with the given id, then replaces it with a hash of newPassword.
+func (s *userService) ChangePassword(id, oldPassword, newPassword string) (*User, error) {
+	for i, u := range s.users {
+		if u.ID != id {
+			continue
+		}
+		if err := checkPassword(u.Password, oldPassword); err != nil {
+			return nil, errors.New("invalid credentials")
+		}
+		hashed, err := hashPassword(newPassword, s.passwordCost)
+		if err != nil {
+			return nil, err
+		}
+		s.users[i].Password = hashed
+		return &s.users[i], nil
+	}
+	return nil, errors.New("user not found")
+}
+
+// UpsertOAuthUser finds or creates the local user for an external identity.
+func (s *userService) UpsertOAuthUser(provider, subject, email, name string) (*User, error) {
+	for i, u := range s.users {
+		if u.Provider != nil && u.Subject != nil && *u.Provider == provider && *u.Subject == subject {
+			s.users[i].Name = name
+			s.users[i].Email = email
+			return &s.users[i], nil
+		}
+	}
+	for i, u := range s.users {
+		if email != "" && u.Email == email {
+			s.users[i].Provider = &provider
+			s.users[i].Subject = &subject
+			s.users[i].Name = name
+			return &s.users[i], nil
+		}
+	}
+	newUser := User{
+		ID:       uuid.NewString(),
+		Name:     name,
+		Email:    email,
+		Provider: &provider,
+		Subject:  &subject,
+		Role:     RoleUser,
+	}
+	s.users = append(s.users, newUser)
+	return &newUser, nil
+}
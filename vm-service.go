@@ -1,29 +1,55 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"sync"
+	"time"
 
 	"github.com/google/uuid"
 )
 
+// Task states shared by every VMService implementation. A task starts
+// pending, moves to running while being processed, and ends succeeded or
+// failed; retrying is a pending task that failed at least once and is
+// waiting out a backoff before its next attempt.
+const (
+	TaskPending   = "pending"
+	TaskRunning   = "running"
+	TaskSucceeded = "succeeded"
+	TaskFailed    = "failed"
+	TaskRetrying  = "retrying"
+)
+
+// defaultMaxTaskAttempts caps how many attempts (the first one included) a
+// task gets before it's marked failed for good.
+const defaultMaxTaskAttempts = 5
+
+// VMCreationTask tracks one asynchronous VM creation job. The requested
+// spec is persisted on the row (not just passed to a goroutine) so a
+// worker can still create the VM after a process restart.
 type VMCreationTask struct {
-	TaskID       string
-	DeploymentID string
+	TaskID        string `gorm:"primaryKey"`
+	DeploymentID  string
+	Status        string `gorm:"index"`
+	Attempts      int
+	MaxAttempts   int
+	NextAttemptAt time.Time
+	Error         string
+
+	RequestName     string
+	RequestNumCPUs  int
+	RequestMemoryMB int
 }
 
 type VMDeployment struct {
-	ID         string
+	ID         string `gorm:"primaryKey"`
 	ResourceID string
-	Status     string
-}
-
-type DeploymentUpdate struct {
-	DeploymentID string
-	ResourceID   string
+	Status     string `gorm:"index"`
 }
 
 type VM struct {
-	ID       string
+	ID       string `gorm:"primaryKey"`
 	Name     string
 	NumCPUs  int
 	MemoryMB int
@@ -31,74 +57,120 @@ type VM struct {
 
 type VMService interface {
 	CreateVM(createVMBody *CreateVMBody) (*VMCreationTask, error)
+	GetTask(id string) (*VMCreationTask, error)
+	GetDeployment(id string) (*VMDeployment, error)
 	ListTasks() ([]VMCreationTask, error)
 	ListDeployments() ([]VMDeployment, error)
 	ListVMs() ([]VM, error)
-	UpdateDeploymentStatus()
+	// Run processes queued tasks until ctx is done, then waits for any
+	// task already in flight to finish before returning.
+	Run(ctx context.Context)
 }
 
 type vmService struct {
-	tasks         []VMCreationTask
-	deployments   sync.Map
-	vms           sync.Map
-	updateChannel chan DeploymentUpdate
+	mu          sync.Mutex
+	tasks       map[string]*VMCreationTask
+	deployments sync.Map
+	vms         sync.Map
+
+	wg sync.WaitGroup
 }
 
+// NewVMService returns an in-memory VMService. Tasks run to completion on
+// their own goroutine as soon as they're created; there's no real queue to
+// be durable, so this is meant for tests and local development rather than
+// production use (see NewGORMVMService for that).
 func NewVMService() VMService {
 	return &vmService{
-		tasks:         []VMCreationTask{},
-		updateChannel: make(chan DeploymentUpdate),
+		tasks: make(map[string]*VMCreationTask),
 	}
 }
 
 func (s *vmService) CreateVM(createVMBody *CreateVMBody) (*VMCreationTask, error) {
-	var task VMCreationTask
-	task.TaskID = uuid.New().String()
+	deployment := VMDeployment{ID: uuid.New().String(), Status: "in-progress"}
+	s.deployments.Store(deployment.ID, deployment)
 
-	deployment := s.createDeployment(createVMBody)
-	task.DeploymentID = deployment.ID
+	task := &VMCreationTask{
+		TaskID:          uuid.New().String(),
+		DeploymentID:    deployment.ID,
+		Status:          TaskPending,
+		MaxAttempts:     defaultMaxTaskAttempts,
+		RequestName:     createVMBody.Name,
+		RequestNumCPUs:  createVMBody.NumCPUs,
+		RequestMemoryMB: createVMBody.MemoryMB,
+	}
+	s.mu.Lock()
+	s.tasks[task.TaskID] = task
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go s.process(task)
 
-	s.tasks = append(s.tasks, task)
-	return &task, nil
+	taskCopy := *task
+	return &taskCopy, nil
 }
 
-func (s *vmService) createDeployment(createVMBody *CreateVMBody) *VMDeployment {
-	var deployment VMDeployment
-	deployment.ID = uuid.New().String()
-	deployment.Status = "in-progress"
+// process creates the VM for task and marks both the task and its
+// deployment as settled. It mutates s.deployments/s.tasks directly rather
+// than handing the result off over a channel, so there's nothing left for
+// Run to wait around draining once a task goroutine is in flight.
+func (s *vmService) process(task *VMCreationTask) {
+	defer s.wg.Done()
+	s.setTaskStatus(task.TaskID, TaskRunning, "")
 
-	s.deployments.Store(deployment.ID, deployment)
-	go s.simulateVMCreation(deployment.ID, createVMBody)
+	vm := VM{
+		ID:       uuid.New().String(),
+		Name:     task.RequestName,
+		NumCPUs:  task.RequestNumCPUs,
+		MemoryMB: task.RequestMemoryMB,
+	}
+	s.vms.Store(vm.ID, vm)
 
-	return &deployment
+	if value, ok := s.deployments.Load(task.DeploymentID); ok {
+		deployment := value.(VMDeployment)
+		deployment.Status = "created"
+		s.deployments.Store(task.DeploymentID, deployment)
+	}
+	s.setTaskStatus(task.TaskID, TaskSucceeded, "")
 }
 
-func (s *vmService) simulateVMCreation(deploymentID string, createVMBody *CreateVMBody) {
-	vm := &VM{
-		ID:       uuid.New().String(),
-		Name:     createVMBody.Name,
-		NumCPUs:  createVMBody.NumCPUs,
-		MemoryMB: createVMBody.MemoryMB,
+func (s *vmService) setTaskStatus(taskID, status, errMsg string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t, ok := s.tasks[taskID]; ok {
+		t.Status = status
+		t.Error = errMsg
 	}
+}
 
-	s.vms.Store(vm.ID, vm)
-	s.updateChannel <- DeploymentUpdate{DeploymentID: deploymentID, ResourceID: vm.ID}
+func (s *vmService) GetTask(id string) (*VMCreationTask, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tasks[id]
+	if !ok {
+		return nil, errors.New("task not found")
+	}
+	taskCopy := *t
+	return &taskCopy, nil
 }
 
-func (s *vmService) UpdateDeploymentStatus() {
-	for update := range s.updateChannel {
-		value, ok := s.deployments.Load(update.DeploymentID)
-		if !ok {
-			continue
-		}
-		deployment := value.(VMDeployment)
-		deployment.Status = "created"
-		s.deployments.Store(update.DeploymentID, deployment)
+func (s *vmService) GetDeployment(id string) (*VMDeployment, error) {
+	value, ok := s.deployments.Load(id)
+	if !ok {
+		return nil, errors.New("deployment not found")
 	}
+	deployment := value.(VMDeployment)
+	return &deployment, nil
 }
 
 func (s *vmService) ListTasks() ([]VMCreationTask, error) {
-	return s.tasks, nil
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tasks := make([]VMCreationTask, 0, len(s.tasks))
+	for _, t := range s.tasks {
+		tasks = append(tasks, *t)
+	}
+	return tasks, nil
 }
 
 func (s *vmService) ListDeployments() ([]VMDeployment, error) {
@@ -120,3 +192,10 @@ func (s *vmService) ListVMs() ([]VM, error) {
 	})
 	return vms, nil
 }
+
+// Run blocks until ctx is done, then waits for any task goroutine already
+// in flight to finish before returning.
+func (s *vmService) Run(ctx context.Context) {
+	<-ctx.Done()
+	s.wg.Wait()
+}